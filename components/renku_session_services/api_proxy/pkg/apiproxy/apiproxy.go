@@ -1,32 +1,53 @@
 package apiproxy
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/config"
+	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/jwks"
+	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/routes"
 	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/tokenstore"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
 )
 
 type ApiProxy struct {
-	config *config.Config
-	store  *tokenstore.TokenStore
+	config       *config.Config
+	store        *tokenstore.TokenStore
+	recorder     Recorder
+	jwksVerifier *jwks.KeySet
+	routes       atomic.Pointer[routeSnapshot]
 }
 
+// RegisterHandlers wires up the proxy's routing table (either the routes
+// config file, hot-reloaded via fsnotify, or - if none is set - the single
+// legacy api/data route) plus the admin endpoints used to inspect and
+// force-reload it.
 func (ap *ApiProxy) RegisterHandlers(e *echo.Echo, commonMiddlewares ...echo.MiddlewareFunc) {
-	dataApiURL := ap.config.RenkuURL.JoinPath("api/data")
-	sessionURL := dataApiURL.JoinPath("sessions", ap.config.SessionID)
-	sessionPath := sessionURL.EscapedPath()
+	ap.setRoutes(ap.loadRouteRules())
 
-	tokenMiddleware := ap.getTokenMiddleware()
-	dataServiceProxy := proxyFromURL(dataApiURL)
+	if ap.config.RoutesConfigPath != "" {
+		watcher, err := routes.NewWatcher(ap.config.RoutesConfigPath)
+		if err != nil {
+			slog.Error("could not watch the route configuration file for changes", "error", err)
+		} else {
+			go watcher.Start(context.Background(), ap.setRoutes)
+		}
+	}
 
-	slog.Info("setting up reverse proxy for session", "path", sessionPath)
-	e.Group(sessionPath, append(commonMiddlewares, tokenMiddleware, dataServiceProxy)...)
+	group := e.Group("", commonMiddlewares...)
+	group.Any("/*", ap.dispatch)
+	group.POST("/admin/reload", ap.handleAdminReload)
+	group.GET("/admin/routes", ap.handleAdminRoutes)
 }
 
 func (ap *ApiProxy) getTokenMiddleware() echo.MiddlewareFunc {
@@ -34,37 +55,84 @@ func (ap *ApiProxy) getTokenMiddleware() echo.MiddlewareFunc {
 		return func(c echo.Context) error {
 			existingToken := c.Request().Header.Get(echo.HeaderAuthorization)
 			if existingToken != "" {
+				if ap.jwksVerifier != nil {
+					bearer := strings.TrimPrefix(existingToken, "Bearer ")
+					if _, err := ap.jwksVerifier.Verify(bearer); err != nil {
+						slog.Info("rejecting request with an unverifiable bearer token", "error", err)
+						return c.NoContent(http.StatusUnauthorized)
+					}
+				}
 				return next(c)
 			}
+			start := time.Now()
 			token, err := ap.store.GetValidRenkuAccessToken()
+			duration := time.Since(start)
 			if err != nil {
+				ap.recorder.ObserveTokenRefresh(TokenRefreshFailure, duration)
 				slog.Info("token could not be loaded", "error", err)
 				return next(c)
 			}
+			ap.recorder.ObserveTokenRefresh(TokenRefreshSuccess, duration)
+			if ttl, ok := tokenTTLSeconds(token); ok {
+				ap.recorder.SetTokenTTL(ttl)
+			}
 			c.Request().Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
 			return next(c)
 		}
 	}
 }
 
-func proxyFromURL(url *url.URL) echo.MiddlewareFunc {
-	if url == nil {
+// tokenTTLSeconds returns the number of seconds until the token's `exp` claim is
+// reached, or ok=false if the token cannot be parsed or carries no expiry.
+func tokenTTLSeconds(token string) (seconds float64, ok bool) {
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil {
+		return 0, false
+	}
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return 0, false
+	}
+	return time.Until(expiresAt.Time).Seconds(), true
+}
+
+// proxyFromURL builds a terminal middleware that forwards requests to target.
+// Plain HTTP requests go through a standard httputil.ReverseProxy; requests
+// asking to switch protocols (e.g. a WebSocket handshake) are instead handed
+// to proxyUpgrade, which Echo's default proxy middleware cannot relay
+// correctly.
+func proxyFromURL(target *url.URL, recorder Recorder, idleTimeout time.Duration) echo.MiddlewareFunc {
+	if target == nil {
 		slog.Error("cannot create a proxy from a nil URL")
 		os.Exit(1)
 	}
-	config := middleware.ProxyConfig{
-		// // the skipper is used to log only
-		// Skipper: func(c echo.Context) bool {
-		// 	// slog.Info("PROXY", "requestID", utils.GetRequestID(c), "destination", url.String())
-		// 	return false
-		// },
-		Balancer: middleware.NewRoundRobinBalancer([]*middleware.ProxyTarget{
-			{
-				Name: url.String(),
-				URL:  url,
-			}}),
+	upstreamPath := target.EscapedPath()
+	reverseProxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.Host = target.Host
+		},
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			recorder.IncInFlight(upstreamPath)
+			defer recorder.DecInFlight(upstreamPath)
+
+			start := time.Now()
+			var err error
+			status := 0
+			if isUpgradeRequest(c.Request()) {
+				status, err = proxyUpgrade(c, target, idleTimeout)
+			} else {
+				reverseProxy.ServeHTTP(c.Response(), c.Request())
+				status = c.Response().Status
+			}
+			recorder.ObserveProxyRequest(c.Request().Method, statusClass(status), upstreamPath, time.Since(start))
+			return err
+		}
 	}
-	return middleware.ProxyWithConfig(config)
 }
 
 type ApiProxyOption func(*ApiProxy)
@@ -81,10 +149,31 @@ func WithTokenStore(store *tokenstore.TokenStore) ApiProxyOption {
 	}
 }
 
+// WithRecorder sets the Recorder used to record proxy metrics. If not provided,
+// NewApiProxy installs a PrometheusRecorder backed by its own registry.
+func WithRecorder(recorder Recorder) ApiProxyOption {
+	return func(ap *ApiProxy) {
+		ap.recorder = recorder
+	}
+}
+
+// WithJWKSVerifier enables signature verification of incoming bearer tokens
+// using the given key set. When not set, bearer tokens supplied by clients
+// are forwarded without verification.
+func WithJWKSVerifier(verifier *jwks.KeySet) ApiProxyOption {
+	return func(ap *ApiProxy) {
+		ap.jwksVerifier = verifier
+	}
+}
+
 func NewApiProxy(options ...ApiProxyOption) (apiProxy *ApiProxy, err error) {
 	server := ApiProxy{}
 	for _, opt := range options {
 		opt(&server)
 	}
+	if server.recorder == nil {
+		recorder, _ := NewPrometheusRecorder()
+		server.recorder = recorder
+	}
 	return &server, nil
 }
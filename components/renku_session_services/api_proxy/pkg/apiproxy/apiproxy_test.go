@@ -0,0 +1,301 @@
+package apiproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/config"
+	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/jwks"
+	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/tokenstore"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// fakeRecorder records every call made to it so tests can assert on the
+// sequence and labels the proxy produces, unlike noopRecorder which discards
+// everything.
+type fakeRecorder struct {
+	mu            sync.Mutex
+	inFlightDelta []int
+	proxyRequests []proxyRequestCall
+	tokenRefresh  []tokenRefreshCall
+	tokenTTLs     []float64
+}
+
+type tokenRefreshCall struct {
+	outcome string
+}
+
+type proxyRequestCall struct {
+	method, statusClass, upstreamPath string
+}
+
+func (r *fakeRecorder) ObserveProxyRequest(method, statusClass, upstreamPath string, _ time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.proxyRequests = append(r.proxyRequests, proxyRequestCall{method: method, statusClass: statusClass, upstreamPath: upstreamPath})
+}
+
+func (r *fakeRecorder) IncInFlight(string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlightDelta = append(r.inFlightDelta, 1)
+}
+
+func (r *fakeRecorder) DecInFlight(string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlightDelta = append(r.inFlightDelta, -1)
+}
+
+func (r *fakeRecorder) ObserveTokenRefresh(outcome string, _ time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokenRefresh = append(r.tokenRefresh, tokenRefreshCall{outcome: outcome})
+}
+
+func (r *fakeRecorder) SetTokenTTL(seconds float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokenTTLs = append(r.tokenTTLs, seconds)
+}
+
+func TestProxyFromURLRecordsInFlightAndStatusClassOnSuccess(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatalf("failed to parse upstream URL: %s", err)
+	}
+
+	recorder := &fakeRecorder{}
+	e := echo.New()
+	e.Any("/*", func(c echo.Context) error { return nil }, proxyFromURL(upstreamURL, recorder, 0))
+	proxy := httptest.NewServer(e)
+	defer proxy.Close()
+
+	res, err := http.Get(proxy.URL + "/anything")
+	if err != nil {
+		t.Fatalf("failed to call the proxy: %s", err)
+	}
+	res.Body.Close()
+
+	if got := len(recorder.inFlightDelta); got != 2 || recorder.inFlightDelta[0] != 1 || recorder.inFlightDelta[1] != -1 {
+		t.Fatalf("expected IncInFlight then DecInFlight exactly once each, got %v", recorder.inFlightDelta)
+	}
+	if len(recorder.proxyRequests) != 1 {
+		t.Fatalf("expected exactly 1 recorded proxy request, got %d", len(recorder.proxyRequests))
+	}
+	if got := recorder.proxyRequests[0].statusClass; got != "2xx" {
+		t.Fatalf("expected a 2xx status class for a successful response, got %q", got)
+	}
+}
+
+// TestProxyFromURLUpgradeFailureIsNotRecordedAsSuccess guards against the bug
+// where a failed WebSocket upgrade - which never writes through Echo's
+// response, leaving c.Response().Status at its default of 200 - was
+// mislabeled as a 2xx in upstream_request_duration_seconds. A dial failure
+// should surface as the "unknown" status class instead, since proxyUpgrade
+// never even got an upstream response to report a real status from.
+func TestProxyFromURLUpgradeFailureIsNotRecordedAsSuccess(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve a port: %s", err)
+	}
+	deadAddr := listener.Addr().String()
+	listener.Close() // nothing is listening anymore; dialing it will be refused
+
+	deadURL, err := url.Parse("http://" + deadAddr)
+	if err != nil {
+		t.Fatalf("failed to parse the dead upstream URL: %s", err)
+	}
+
+	recorder := &fakeRecorder{}
+	e := echo.New()
+	e.Any("/*", func(c echo.Context) error { return nil }, proxyFromURL(deadURL, recorder, 0))
+	proxy := httptest.NewServer(e)
+	defer proxy.Close()
+
+	req, err := http.NewRequest(http.MethodGet, proxy.URL+"/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to build the request: %s", err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to call the proxy: %s", err)
+	}
+	res.Body.Close()
+
+	if len(recorder.proxyRequests) != 1 {
+		t.Fatalf("expected exactly 1 recorded proxy request, got %d", len(recorder.proxyRequests))
+	}
+	if got := recorder.proxyRequests[0].statusClass; got != "unknown" {
+		t.Fatalf("expected the failed upgrade to be recorded as \"unknown\", not a fabricated 2xx, got %q", got)
+	}
+}
+
+const testJWKSIssuer = "https://renku.example.com/auth/realms/test"
+const testJWKSAudience = "test-audience"
+
+// newFakeJWKSServer serves a single RSA key as a JWKS document, so a
+// jwks.KeySet can be pointed at it without standing up a real Keycloak.
+func newFakeJWKSServer(t *testing.T, kid string, pub *rsa.PublicKey) *httptest.Server {
+	t.Helper()
+	doc := map[string]any{
+		"keys": []map[string]any{{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(doc)
+	}))
+}
+
+func newTestJWKSVerifier(t *testing.T) *jwks.KeySet {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the test RSA key: %s", err)
+	}
+	server := newFakeJWKSServer(t, "test-key-1", &key.PublicKey)
+	t.Cleanup(server.Close)
+
+	verifier := jwks.New(server.URL, testJWKSIssuer, testJWKSAudience, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := verifier.Refresh(ctx); err != nil {
+		t.Fatalf("failed to refresh the jwks verifier: %s", err)
+	}
+	return verifier
+}
+
+// TestGetTokenMiddlewareRejectsAnUnverifiableBearerToken exercises the 401
+// path: a client-supplied bearer token that the jwksVerifier cannot verify
+// (here, signed by a key the verifier never cached) must be rejected rather
+// than forwarded to the next handler.
+func TestGetTokenMiddlewareRejectsAnUnverifiableBearerToken(t *testing.T) {
+	verifier := newTestJWKSVerifier(t)
+	ap := &ApiProxy{config: &config.Config{}, recorder: &fakeRecorder{}, jwksVerifier: verifier}
+
+	unknownKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate an unrelated RSA key: %s", err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    testJWKSIssuer,
+		Audience:  jwt.ClaimStrings{testJWKSAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	forged.Header["kid"] = "unknown-kid"
+	forgedString, err := forged.SignedString(unknownKey)
+	if err != nil {
+		t.Fatalf("failed to sign the forged token: %s", err)
+	}
+
+	nextCalled := false
+	handler := ap.getTokenMiddleware()(func(c echo.Context) error {
+		nextCalled = true
+		return nil
+	})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+forgedString)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if err := handler(c); err != nil {
+		t.Fatalf("unexpected error from the middleware: %s", err)
+	}
+	if nextCalled {
+		t.Fatal("expected the unverifiable token to be rejected before reaching the next handler")
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a 401 for an unverifiable bearer token, got %d", rec.Code)
+	}
+}
+
+// TestGetTokenMiddlewareRecordsTokenRefreshAndTTL exercises the token-store
+// path (no bearer token supplied by the client) and asserts that the
+// middleware reports the refresh outcome and the new token's TTL through the
+// Recorder, as request #1 added the interface to allow.
+func TestGetTokenMiddlewareRecordsTokenRefreshAndTTL(t *testing.T) {
+	newAccessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	newAccessTokenString, err := newAccessToken.SignedString([]byte("unused"))
+	if err != nil {
+		t.Fatalf("failed to sign the refreshed access token: %s", err)
+	}
+
+	keycloak := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": newAccessTokenString})
+	}))
+	defer keycloak.Close()
+	keycloakURL, err := url.Parse(keycloak.URL)
+	if err != nil {
+		t.Fatalf("failed to parse the fake keycloak URL: %s", err)
+	}
+
+	expiredClaims := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour))}
+	expiredToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, expiredClaims).SignedString([]byte("unused"))
+	if err != nil {
+		t.Fatalf("failed to sign the expired access token: %s", err)
+	}
+
+	store := tokenstore.New(&config.Config{
+		RenkuURL:          keycloakURL,
+		RenkuRealm:        "test-realm",
+		RenkuClientID:     "test-client",
+		RenkuClientSecret: "test-secret",
+		RenkuAccessToken:  config.RedactedString(expiredToken),
+		RenkuRefreshToken: "initial-refresh-token",
+	})
+
+	recorder := &fakeRecorder{}
+	ap := &ApiProxy{config: &config.Config{}, recorder: recorder, store: store}
+
+	var forwardedAuth string
+	handler := ap.getTokenMiddleware()(func(c echo.Context) error {
+		forwardedAuth = c.Request().Header.Get(echo.HeaderAuthorization)
+		return nil
+	})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if err := handler(c); err != nil {
+		t.Fatalf("unexpected error from the middleware: %s", err)
+	}
+	if want := "Bearer " + newAccessTokenString; forwardedAuth != want {
+		t.Fatalf("expected the refreshed token to be forwarded, got %q, want %q", forwardedAuth, want)
+	}
+	if len(recorder.tokenRefresh) != 1 || recorder.tokenRefresh[0].outcome != TokenRefreshSuccess {
+		t.Fatalf("expected exactly one successful token refresh to be recorded, got %+v", recorder.tokenRefresh)
+	}
+	if len(recorder.tokenTTLs) != 1 || recorder.tokenTTLs[0] <= 0 {
+		t.Fatalf("expected a positive TTL to be recorded for the refreshed token, got %v", recorder.tokenTTLs)
+	}
+}
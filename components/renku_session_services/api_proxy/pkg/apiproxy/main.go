@@ -10,9 +10,12 @@ import (
 	"time"
 
 	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/config"
+	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/jwks"
 	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/tokenstore"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func Run() {
@@ -23,11 +26,13 @@ func Run() {
 	}
 	slog.Info("loaded configuration", "config", config)
 
-	e, err := createServer(config)
+	recorder, registry := NewPrometheusRecorder()
+	e, err := createServer(config, recorder)
 	if err != nil {
 		slog.Error("creating the API proxy failed", "error", err)
 		os.Exit(1)
 	}
+	metricsServer := createMetricsServer(registry)
 
 	// Start server
 	address := fmt.Sprintf("%s:%d", config.Host, config.Port)
@@ -40,6 +45,18 @@ func Run() {
 		}
 	}()
 
+	// Start the metrics server on its own listener so that scraping does not
+	// go through the session path routing on the main server.
+	metricsAddress := fmt.Sprintf("%s:%d", config.MetricsHost, config.MetricsPort)
+	slog.Info("starting the metrics server on address " + metricsAddress)
+	go func() {
+		err := metricsServer.Start(metricsAddress)
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("shutting down the metrics server gracefuly failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
 	// Wait for interrupt signal to gracefully shutdown the server with a timeout of 10 seconds.
 	// Use a buffered channel to avoid missing signals as recommended for signal.Notify
 	quit := make(chan os.Signal, 1)
@@ -52,10 +69,14 @@ func Run() {
 		slog.Error("shutting down the server gracefully failed", "error", err)
 		os.Exit(1)
 	}
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		slog.Error("shutting down the metrics server gracefully failed", "error", err)
+		os.Exit(1)
+	}
 }
 
 // createServer creates the API Proxy server
-func createServer(config config.Config) (e *echo.Echo, err error) {
+func createServer(config config.Config, recorder Recorder) (e *echo.Echo, err error) {
 	e = echo.New()
 	e.Pre(middleware.RequestID(), middleware.RemoveTrailingSlash())
 	e.Use(middleware.Recover())
@@ -70,7 +91,18 @@ func createServer(config config.Config) (e *echo.Echo, err error) {
 	})
 
 	store := tokenstore.New(&config)
-	ap, err := NewApiProxy(WithConfig(config), WithTokenStore(store))
+	opts := []ApiProxyOption{WithConfig(config), WithTokenStore(store), WithRecorder(recorder)}
+
+	if config.JWKSVerify {
+		verifier, err := newJWKSVerifier(config)
+		if err != nil {
+			return nil, err
+		}
+		go verifier.StartBackgroundRefresh(context.Background())
+		opts = append(opts, WithJWKSVerifier(verifier))
+	}
+
+	ap, err := NewApiProxy(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -78,3 +110,30 @@ func createServer(config config.Config) (e *echo.Echo, err error) {
 
 	return e, nil
 }
+
+// newJWKSVerifier builds a KeySet pointed at the configured realm's JWKS
+// endpoint and performs an initial fetch so the proxy doesn't start up with
+// an empty cache.
+func newJWKSVerifier(config config.Config) (*jwks.KeySet, error) {
+	jwksURL := config.RenkuURL.JoinPath(fmt.Sprintf("auth/realms/%s/protocol/openid-connect/certs", config.RenkuRealm))
+	issuer := config.RenkuURL.JoinPath(fmt.Sprintf("auth/realms/%s", config.RenkuRealm))
+	verifier := jwks.New(jwksURL.String(), issuer.String(), config.ExpectedAudience, config.JWKSRefreshInterval)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := verifier.Refresh(ctx); err != nil {
+		return nil, fmt.Errorf("fetching the initial jwks failed: %w", err)
+	}
+	return verifier, nil
+}
+
+// createMetricsServer creates a minimal server exposing /metrics for the given
+// registry. It is started on its own listener, separate from the session
+// routing on the main server, so that scraping cannot be affected by it.
+func createMetricsServer(registry *prometheus.Registry) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
+	return e
+}
@@ -0,0 +1,116 @@
+package apiproxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Recorder records the metrics the API proxy cares about. It is implemented
+// by PrometheusRecorder in production and can be swapped out in tests with a
+// fake to assert on the calls the proxy makes without standing up a real
+// Prometheus registry.
+type Recorder interface {
+	// ObserveProxyRequest records a completed proxied request.
+	ObserveProxyRequest(method, statusClass, upstreamPath string, duration time.Duration)
+	// IncInFlight marks the start of a proxied request to upstreamPath.
+	IncInFlight(upstreamPath string)
+	// DecInFlight marks the end of a proxied request to upstreamPath.
+	DecInFlight(upstreamPath string)
+	// ObserveTokenRefresh records an attempt to obtain a valid Renku access token.
+	ObserveTokenRefresh(outcome string, duration time.Duration)
+	// SetTokenTTL records the remaining lifetime, in seconds, of the current access token.
+	SetTokenTTL(seconds float64)
+}
+
+// Outcomes reported to ObserveTokenRefresh.
+const (
+	TokenRefreshSuccess = "success"
+	TokenRefreshFailure = "failure"
+)
+
+// PrometheusRecorder is the default Recorder, backed by client_golang collectors
+// registered on a dedicated registry so that scraping can be served from a
+// separate listener than the proxied session traffic.
+type PrometheusRecorder struct {
+	requestDuration   *prometheus.HistogramVec
+	requestsInFlight  *prometheus.GaugeVec
+	tokenRefreshTotal *prometheus.CounterVec
+	tokenRefreshTime  *prometheus.HistogramVec
+	tokenTTLSeconds   prometheus.Gauge
+}
+
+// NewPrometheusRecorder creates a PrometheusRecorder and registers its collectors
+// on a fresh registry, which it returns alongside the recorder so callers can
+// serve it from their metrics listener.
+func NewPrometheusRecorder() (*PrometheusRecorder, *prometheus.Registry) {
+	registry := prometheus.NewRegistry()
+	rec := &PrometheusRecorder{
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "api_proxy",
+			Name:      "upstream_request_duration_seconds",
+			Help:      "Latency of requests proxied to the upstream, in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "status_class", "upstream_path"}),
+		requestsInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "api_proxy",
+			Name:      "upstream_requests_in_flight",
+			Help:      "Number of proxied requests currently being served.",
+		}, []string{"upstream_path"}),
+		tokenRefreshTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "api_proxy",
+			Name:      "token_refresh_total",
+			Help:      "Number of attempts to obtain a valid Renku access token, by outcome.",
+		}, []string{"outcome"}),
+		tokenRefreshTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "api_proxy",
+			Name:      "token_refresh_duration_seconds",
+			Help:      "Time spent obtaining a valid Renku access token, in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"outcome"}),
+		tokenTTLSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "api_proxy",
+			Name:      "token_ttl_seconds",
+			Help:      "Remaining lifetime of the current Renku access token, in seconds, derived from its exp claim.",
+		}),
+	}
+	registry.MustRegister(
+		rec.requestDuration,
+		rec.requestsInFlight,
+		rec.tokenRefreshTotal,
+		rec.tokenRefreshTime,
+		rec.tokenTTLSeconds,
+	)
+	return rec, registry
+}
+
+func (r *PrometheusRecorder) ObserveProxyRequest(method, statusClass, upstreamPath string, duration time.Duration) {
+	r.requestDuration.WithLabelValues(method, statusClass, upstreamPath).Observe(duration.Seconds())
+}
+
+func (r *PrometheusRecorder) IncInFlight(upstreamPath string) {
+	r.requestsInFlight.WithLabelValues(upstreamPath).Inc()
+}
+
+func (r *PrometheusRecorder) DecInFlight(upstreamPath string) {
+	r.requestsInFlight.WithLabelValues(upstreamPath).Dec()
+}
+
+func (r *PrometheusRecorder) ObserveTokenRefresh(outcome string, duration time.Duration) {
+	r.tokenRefreshTotal.WithLabelValues(outcome).Inc()
+	r.tokenRefreshTime.WithLabelValues(outcome).Observe(duration.Seconds())
+}
+
+func (r *PrometheusRecorder) SetTokenTTL(seconds float64) {
+	r.tokenTTLSeconds.Set(seconds)
+}
+
+// statusClass buckets an HTTP status code into the "2xx"/"4xx"/"5xx" shape used
+// to label request metrics, so histograms don't fan out per status code.
+func statusClass(status int) string {
+	if status <= 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
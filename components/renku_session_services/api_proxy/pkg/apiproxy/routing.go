@@ -0,0 +1,179 @@
+package apiproxy
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/routes"
+	"github.com/labstack/echo/v4"
+)
+
+// compiledRoute pairs a RouteRule with its fully-assembled request handler
+// (token injection, prefix stripping and proxying), built once when the
+// routing table is (re)loaded rather than on every request.
+type compiledRoute struct {
+	rule    routes.RouteRule
+	handler echo.HandlerFunc
+}
+
+// routeSnapshot is an immutable routing table. ap.routes holds an
+// atomic.Pointer to the current one: a request reads the pointer exactly once
+// and keeps using that value for its entire lifetime, so swapping in a new
+// snapshot never pulls the table out from under an in-flight request and
+// needs no explicit drain logic of its own.
+type routeSnapshot struct {
+	routes []compiledRoute
+}
+
+// defaultRouteRules reproduces the proxy's original, pre-multi-route
+// behavior: a single upstream at api/data, under the session's own path,
+// with token injection on. It is used whenever no routes config file is set.
+func (ap *ApiProxy) defaultRouteRules() []routes.RouteRule {
+	dataApiURL := ap.config.RenkuURL.JoinPath("api/data")
+	sessionURL := dataApiURL.JoinPath("sessions", ap.config.SessionID)
+	return []routes.RouteRule{{
+		PathPrefix:  sessionURL.EscapedPath(),
+		Upstream:    dataApiURL,
+		InjectToken: true,
+		StripPrefix: false,
+	}}
+}
+
+// loadRouteRules returns the routing table to start from: the configured
+// routes file if one is set, falling back to defaultRouteRules if the file is
+// missing or invalid so a bad config doesn't take the proxy down entirely.
+func (ap *ApiProxy) loadRouteRules() []routes.RouteRule {
+	if ap.config.RoutesConfigPath == "" {
+		return ap.defaultRouteRules()
+	}
+	rules, err := routes.Load(ap.config.RoutesConfigPath)
+	if err != nil {
+		slog.Error("could not load the route configuration, falling back to the static single-upstream route", "error", err)
+		return ap.defaultRouteRules()
+	}
+	return rules
+}
+
+// compileRoute builds the terminal handler for a single route: proxying,
+// optionally preceded by prefix stripping and/or token injection.
+func (ap *ApiProxy) compileRoute(rule routes.RouteRule) echo.HandlerFunc {
+	handler := proxyFromURL(rule.Upstream, ap.recorder, ap.config.ProxyIdleTimeout)(notFound)
+
+	if rule.StripPrefix {
+		next := handler
+		handler = func(c echo.Context) error {
+			req := c.Request()
+			stripped := strings.TrimPrefix(req.URL.Path, rule.PathPrefix)
+			if !strings.HasPrefix(stripped, "/") {
+				stripped = "/" + stripped
+			}
+			req.URL.Path = stripped
+			return next(c)
+		}
+	}
+
+	if rule.InjectToken {
+		handler = ap.getTokenMiddleware()(handler)
+	}
+
+	return handler
+}
+
+func notFound(c echo.Context) error {
+	return c.NoContent(http.StatusNotFound)
+}
+
+// setRoutes compiles rules into a new snapshot and atomically installs it.
+func (ap *ApiProxy) setRoutes(rules []routes.RouteRule) {
+	compiled := make([]compiledRoute, 0, len(rules))
+	for _, rule := range rules {
+		compiled = append(compiled, compiledRoute{rule: rule, handler: ap.compileRoute(rule)})
+	}
+	ap.routes.Store(&routeSnapshot{routes: compiled})
+	slog.Info("routing table updated", "routes", len(compiled))
+}
+
+// matchesPathPrefix reports whether path falls under prefix, treating prefix
+// as a path segment rather than a bare string: it matches only an exact hit
+// or a prefix followed by "/", so a rule for "/api/data" does not also claim
+// "/api/database".
+func matchesPathPrefix(path, prefix string) bool {
+	if path == prefix {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// dispatch forwards a request to the first route whose PathPrefix matches.
+func (ap *ApiProxy) dispatch(c echo.Context) error {
+	snapshot := ap.routes.Load()
+	if snapshot == nil {
+		return notFound(c)
+	}
+	path := c.Request().URL.Path
+	for _, route := range snapshot.routes {
+		if matchesPathPrefix(path, route.rule.PathPrefix) {
+			return route.handler(c)
+		}
+	}
+	return notFound(c)
+}
+
+// authorizedAdmin reports whether the request carries the shared secret
+// configured for the admin endpoints, using a constant-time comparison to
+// avoid leaking the secret's value through response-timing side channels.
+func (ap *ApiProxy) authorizedAdmin(c echo.Context) bool {
+	secret := string(ap.config.AdminSharedSecret)
+	if secret == "" {
+		return false
+	}
+	provided := c.Request().Header.Get("X-Admin-Secret")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) == 1
+}
+
+// handleAdminReload re-reads the routes config file on demand, for
+// environments where filesystem watches are unreliable.
+func (ap *ApiProxy) handleAdminReload(c echo.Context) error {
+	if !ap.authorizedAdmin(c) {
+		return c.NoContent(http.StatusUnauthorized)
+	}
+	if ap.config.RoutesConfigPath == "" {
+		return c.String(http.StatusBadRequest, "no route configuration file is configured")
+	}
+	rules, err := routes.Load(ap.config.RoutesConfigPath)
+	if err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("failed to reload the route configuration: %s", err))
+	}
+	ap.setRoutes(rules)
+	return c.NoContent(http.StatusNoContent)
+}
+
+type routeView struct {
+	PathPrefix  string `json:"path_prefix"`
+	Upstream    string `json:"upstream"`
+	InjectToken bool   `json:"inject_token"`
+	StripPrefix bool   `json:"strip_prefix"`
+}
+
+// handleAdminRoutes exposes the currently effective routing table.
+func (ap *ApiProxy) handleAdminRoutes(c echo.Context) error {
+	if !ap.authorizedAdmin(c) {
+		return c.NoContent(http.StatusUnauthorized)
+	}
+	snapshot := ap.routes.Load()
+	views := make([]routeView, 0)
+	if snapshot != nil {
+		for _, route := range snapshot.routes {
+			views = append(views, routeView{
+				PathPrefix:  route.rule.PathPrefix,
+				Upstream:    route.rule.Upstream.String(),
+				InjectToken: route.rule.InjectToken,
+				StripPrefix: route.rule.StripPrefix,
+			})
+		}
+	}
+	return c.JSON(http.StatusOK, views)
+}
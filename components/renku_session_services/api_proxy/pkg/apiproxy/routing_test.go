@@ -0,0 +1,307 @@
+package apiproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/config"
+	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/routes"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMatchesPathPrefix(t *testing.T) {
+	tests := []struct {
+		path, prefix string
+		want         bool
+	}{
+		{path: "/api/data", prefix: "/api/data", want: true},
+		{path: "/api/data/sessions/1", prefix: "/api/data", want: true},
+		{path: "/api/database", prefix: "/api/data", want: false},
+		{path: "/api/databases/1", prefix: "/api/data", want: false},
+		{path: "/api/dat", prefix: "/api/data", want: false},
+	}
+	for _, tt := range tests {
+		if got := matchesPathPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("matchesPathPrefix(%q, %q) = %v, want %v", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// newTestApiProxy builds an ApiProxy wired up with a real Echo instance but no
+// token store, suitable for exercising routing and admin endpoints directly.
+func newTestApiProxy(t *testing.T, adminSecret string) (*ApiProxy, *echo.Echo) {
+	t.Helper()
+	renkuURL, err := url.Parse("http://renku.invalid")
+	if err != nil {
+		t.Fatalf("failed to parse the test renku URL: %s", err)
+	}
+	ap, err := NewApiProxy(
+		WithConfig(config.Config{
+			SessionID:         "test-session",
+			RenkuURL:          renkuURL,
+			AdminSharedSecret: config.RedactedString(adminSecret),
+		}),
+		WithRecorder(noopRecorder{}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create the api proxy: %s", err)
+	}
+	e := echo.New()
+	ap.RegisterHandlers(e)
+	return ap, e
+}
+
+func TestDispatchMatchesFirstRouteByPrefix(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Path", r.URL.Path)
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatalf("failed to parse upstream URL: %s", err)
+	}
+
+	ap, e := newTestApiProxy(t, "")
+	ap.setRoutes([]routes.RouteRule{
+		{PathPrefix: "/api/data", Upstream: upstreamURL},
+		{PathPrefix: "/api/database", Upstream: upstreamURL},
+	})
+
+	tests := []struct {
+		path           string
+		wantPathOnWire string
+	}{
+		{path: "/api/data", wantPathOnWire: "/api/data"},
+		{path: "/api/data/sessions/1", wantPathOnWire: "/api/data/sessions/1"},
+		{path: "/api/database", wantPathOnWire: "/api/database"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if got := rec.Result().Header.Get("X-Upstream-Path"); got != tt.wantPathOnWire {
+			t.Errorf("request to %q: expected it to reach upstream path %q, got %q", tt.path, tt.wantPathOnWire, got)
+		}
+	}
+}
+
+func TestDispatchNoMatchIsNotFound(t *testing.T) {
+	ap, e := newTestApiProxy(t, "")
+	ap.setRoutes([]routes.RouteRule{{PathPrefix: "/api/data", Upstream: &url.URL{Scheme: "http", Host: "example.invalid"}}})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/unknown", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unmatched path, got %d", rec.Code)
+	}
+}
+
+func TestSetRoutesSwapsAtomically(t *testing.T) {
+	ap, _ := newTestApiProxy(t, "")
+	upstreamA := &url.URL{Scheme: "http", Host: "a.invalid"}
+	upstreamB := &url.URL{Scheme: "http", Host: "b.invalid"}
+
+	ap.setRoutes([]routes.RouteRule{{PathPrefix: "/api/data", Upstream: upstreamA}})
+	first := ap.routes.Load()
+	if got := first.routes[0].rule.Upstream.Host; got != "a.invalid" {
+		t.Fatalf("expected the first snapshot to point at a.invalid, got %s", got)
+	}
+
+	ap.setRoutes([]routes.RouteRule{{PathPrefix: "/api/data", Upstream: upstreamB}})
+	second := ap.routes.Load()
+	if got := second.routes[0].rule.Upstream.Host; got != "b.invalid" {
+		t.Fatalf("expected the second snapshot to point at b.invalid, got %s", got)
+	}
+	if got := first.routes[0].rule.Upstream.Host; got != "a.invalid" {
+		t.Fatalf("expected the first snapshot to remain unchanged after the swap, got %s", got)
+	}
+}
+
+func TestAdminEndpointsRequireTheSharedSecret(t *testing.T) {
+	_, e := newTestApiProxy(t, "top-secret")
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/routes", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the admin secret, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/routes", nil)
+	req.Header.Set("X-Admin-Secret", "wrong-secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with the wrong admin secret, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/routes", nil)
+	req.Header.Set("X-Admin-Secret", "top-secret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the correct admin secret, got %d", rec.Code)
+	}
+}
+
+func TestAdminEndpointsDisabledWithoutASecret(t *testing.T) {
+	_, e := newTestApiProxy(t, "")
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/routes", nil)
+	req.Header.Set("X-Admin-Secret", "")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 when no admin secret is configured, got %d", rec.Code)
+	}
+}
+
+func TestAdminReloadRejectsWithoutARoutesConfigPath(t *testing.T) {
+	renkuURL, err := url.Parse("http://renku.invalid")
+	if err != nil {
+		t.Fatalf("failed to parse the test renku URL: %s", err)
+	}
+	ap, err := NewApiProxy(
+		WithConfig(config.Config{SessionID: "test-session", RenkuURL: renkuURL, AdminSharedSecret: "top-secret"}),
+		WithRecorder(noopRecorder{}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create the api proxy: %s", err)
+	}
+	e := echo.New()
+	ap.RegisterHandlers(e)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	req.Header.Set("X-Admin-Secret", "top-secret")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 when no routes config path is set, got %d", rec.Code)
+	}
+}
+
+// writeRouteFile (re)writes a single-route YAML routing table at path.
+func writeRouteFile(t *testing.T, path, upstream string) {
+	t.Helper()
+	contents := "routes:\n  - path_prefix: /api/data\n    upstream: " + upstream + "\n"
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write the route configuration file: %s", err)
+	}
+}
+
+// newTestApiProxyWithRoutesFile is like newTestApiProxy but configures
+// RoutesConfigPath, so RegisterHandlers starts the fsnotify watcher and the
+// /admin/reload endpoint has a file to re-read.
+func newTestApiProxyWithRoutesFile(t *testing.T, routesConfigPath string) (*ApiProxy, *echo.Echo) {
+	t.Helper()
+	renkuURL, err := url.Parse("http://renku.invalid")
+	if err != nil {
+		t.Fatalf("failed to parse the test renku URL: %s", err)
+	}
+	ap, err := NewApiProxy(
+		WithConfig(config.Config{
+			SessionID:         "test-session",
+			RenkuURL:          renkuURL,
+			RoutesConfigPath:  routesConfigPath,
+			AdminSharedSecret: "top-secret",
+		}),
+		WithRecorder(noopRecorder{}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create the api proxy: %s", err)
+	}
+	e := echo.New()
+	ap.RegisterHandlers(e)
+	return ap, e
+}
+
+// upstreamHost returns the host:port a httptest.Server listens on, for
+// comparison against the Host header an upstream handler observed.
+func upstreamHost(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("failed to parse the test upstream URL: %s", err)
+	}
+	return u.Host
+}
+
+func TestWatcherHotReloadsOnFileChange(t *testing.T) {
+	upstreamA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Name", r.Host)
+	}))
+	defer upstreamA.Close()
+	upstreamB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Name", r.Host)
+	}))
+	defer upstreamB.Close()
+
+	routesPath := filepath.Join(t.TempDir(), "routes.yaml")
+	writeRouteFile(t, routesPath, upstreamA.URL)
+
+	_, e := newTestApiProxyWithRoutesFile(t, routesPath)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/data", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if got := rec.Result().Header.Get("X-Upstream-Name"); got != upstreamHost(t, upstreamA) {
+		t.Fatalf("expected the initial table to route to upstream A's host, got %q", got)
+	}
+
+	writeRouteFile(t, routesPath, upstreamB.URL)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req := httptest.NewRequest(http.MethodGet, "/api/data", nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if got := rec.Result().Header.Get("X-Upstream-Name"); got == upstreamHost(t, upstreamB) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("routing table was not hot-reloaded to upstream B within the deadline")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAdminReloadPicksUpARewrittenRoutesFile(t *testing.T) {
+	upstreamA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Name", r.Host)
+	}))
+	defer upstreamA.Close()
+	upstreamB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Name", r.Host)
+	}))
+	defer upstreamB.Close()
+
+	routesPath := filepath.Join(t.TempDir(), "routes.yaml")
+	writeRouteFile(t, routesPath, upstreamA.URL)
+
+	_, e := newTestApiProxyWithRoutesFile(t, routesPath)
+
+	// Overwrite the file (simulating an editor/config tool) without relying on
+	// fsnotify, then force the reload through the admin endpoint.
+	writeRouteFile(t, routesPath, upstreamB.URL)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	req.Header.Set("X-Admin-Secret", "top-secret")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 from a successful reload, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/data", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if got := rec.Result().Header.Get("X-Upstream-Name"); got != upstreamHost(t, upstreamB) {
+		t.Fatalf("expected the forced reload to pick up upstream B, got %q", got)
+	}
+}
@@ -0,0 +1,166 @@
+package apiproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// isUpgradeRequest reports whether req is asking to switch protocols (e.g. to
+// a WebSocket), per RFC 7230 the Connection header carries "upgrade" as one of
+// its comma-separated tokens alongside a non-empty Upgrade header.
+func isUpgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, token := range strings.Split(req.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyUpgrade hand-rolls the upgrade handshake: it dials the upstream itself,
+// relays the client's request (with whatever Authorization header the token
+// middleware injected still attached), relays the upstream's response back to
+// the hijacked client connection, and then splices the two raw connections
+// together so the HTTP stack is no longer in the way of the WebSocket frames.
+// It hijacks the connection, so c.Response().Status is never updated by this
+// path; callers needing the upstream's status for metrics must use the
+// returned status code instead, which is 0 if the handshake failed before a
+// response was ever read.
+func proxyUpgrade(c echo.Context, target *url.URL, idleTimeout time.Duration) (status int, err error) {
+	req := c.Request()
+	req.Host = target.Host
+
+	upstreamConn, err := dialUpstream(target)
+	if err != nil {
+		return 0, fmt.Errorf("dialing upstream for upgrade failed: %w", err)
+	}
+	upstreamConn = newDeadlineConn(upstreamConn, idleTimeout)
+
+	if err := req.Write(upstreamConn); err != nil {
+		upstreamConn.Close()
+		return 0, fmt.Errorf("writing upgrade request upstream failed: %w", err)
+	}
+
+	upstreamReader := bufio.NewReader(upstreamConn)
+	res, err := http.ReadResponse(upstreamReader, req)
+	if err != nil {
+		upstreamConn.Close()
+		return 0, fmt.Errorf("reading upgrade response from upstream failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	hijacker, ok := c.Response().Writer.(http.Hijacker)
+	if !ok {
+		upstreamConn.Close()
+		return res.StatusCode, fmt.Errorf("the response writer does not support hijacking")
+	}
+	rawClientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		upstreamConn.Close()
+		return res.StatusCode, fmt.Errorf("hijacking the client connection failed: %w", err)
+	}
+	clientConn := newDeadlineConn(rawClientConn, idleTimeout)
+
+	if err := res.Write(clientConn); err != nil {
+		clientConn.Close()
+		upstreamConn.Close()
+		return res.StatusCode, fmt.Errorf("writing upgrade response to client failed: %w", err)
+	}
+
+	// clientBuf may already hold bytes the server's bufio.Reader read ahead of
+	// the hijack; replay those before reading any more off the (now
+	// deadline-wrapped) raw connection.
+	var clientReader io.Reader = clientConn
+	if buffered := clientBuf.Reader.Buffered(); buffered > 0 {
+		pending := make([]byte, buffered)
+		if _, err := io.ReadFull(clientBuf.Reader, pending); err != nil {
+			clientConn.Close()
+			upstreamConn.Close()
+			return res.StatusCode, fmt.Errorf("draining buffered client bytes failed: %w", err)
+		}
+		clientReader = io.MultiReader(bytes.NewReader(pending), clientConn)
+	}
+
+	splice(clientConn, upstreamConn, clientReader, upstreamReader)
+	return res.StatusCode, nil
+}
+
+// dialUpstream opens a raw TCP (or TLS, for https/wss targets) connection to target.
+func dialUpstream(target *url.URL) (net.Conn, error) {
+	addr := target.Host
+	if !strings.Contains(addr, ":") {
+		if target.Scheme == "https" || target.Scheme == "wss" {
+			addr = net.JoinHostPort(addr, "443")
+		} else {
+			addr = net.JoinHostPort(addr, "80")
+		}
+	}
+	if target.Scheme == "https" || target.Scheme == "wss" {
+		return tls.Dial("tcp", addr, &tls.Config{ServerName: target.Hostname()})
+	}
+	return net.Dial("tcp", addr)
+}
+
+// splice copies data bidirectionally between the client and upstream
+// connections until either side closes or goes idle. Both copies share a
+// context that either one cancels as soon as it returns (client gone, upstream
+// gone, or an idle timeout tripping a read deadline); the cancellation then
+// closes both connections so the still-running copy unblocks from its Read
+// instead of leaking its goroutine.
+func splice(clientConn, upstreamConn net.Conn, clientReader, upstreamReader io.Reader) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{}, 2)
+	copyAndSignal := func(dst io.Writer, src io.Reader) {
+		_, _ = io.Copy(dst, src)
+		cancel()
+		done <- struct{}{}
+	}
+
+	go copyAndSignal(upstreamConn, clientReader)
+	go copyAndSignal(clientConn, upstreamReader)
+
+	<-ctx.Done()
+	clientConn.Close()
+	upstreamConn.Close()
+	<-done
+	<-done
+}
+
+// deadlineConn resets a read/write deadline on every operation, so an
+// otherwise-idle hijacked connection is eventually closed instead of leaking.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func newDeadlineConn(conn net.Conn, timeout time.Duration) net.Conn {
+	if timeout <= 0 {
+		return conn
+	}
+	return &deadlineConn{Conn: conn, timeout: timeout}
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
@@ -0,0 +1,135 @@
+package apiproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// noopRecorder discards every metric; it satisfies Recorder so tests don't
+// need a real Prometheus registry.
+type noopRecorder struct{}
+
+func (noopRecorder) ObserveProxyRequest(string, string, string, time.Duration) {}
+func (noopRecorder) IncInFlight(string)                                        {}
+func (noopRecorder) DecInFlight(string)                                        {}
+func (noopRecorder) ObserveTokenRefresh(string, time.Duration)                 {}
+func (noopRecorder) SetTokenTTL(float64)                                       {}
+
+// newEchoUpstream starts a plain WebSocket server that echoes back whatever it receives.
+func newEchoUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upstream failed to upgrade the connection: %s", err)
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, message); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func newProxyServer(t *testing.T, upstream *httptest.Server, idleTimeout time.Duration) *httptest.Server {
+	t.Helper()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatalf("failed to parse upstream URL: %s", err)
+	}
+	e := echo.New()
+	e.Any("/*", func(c echo.Context) error { return nil }, proxyFromURL(upstreamURL, noopRecorder{}, idleTimeout))
+	return httptest.NewServer(e)
+}
+
+func wsURL(httpURL string) string {
+	return "ws" + httpURL[len("http"):]
+}
+
+func TestWebSocketFramesRoundTrip(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	defer upstream.Close()
+	proxy := newProxyServer(t, upstream, time.Minute)
+	defer proxy.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	if err != nil {
+		t.Fatalf("failed to dial the proxy: %s", err)
+	}
+	defer conn.Close()
+
+	for _, msg := range []string{"hello", "world"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("failed to write message: %s", err)
+		}
+		_, got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read echoed message: %s", err)
+		}
+		if string(got) != msg {
+			t.Fatalf("expected echoed message %q, got %q", msg, got)
+		}
+	}
+}
+
+func TestWebSocketGoroutinesExitOnClientDisconnect(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	defer upstream.Close()
+	proxy := newProxyServer(t, upstream, time.Minute)
+	defer proxy.Close()
+
+	baseline := runtime.NumGoroutine()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	if err != nil {
+		t.Fatalf("failed to dial the proxy: %s", err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatalf("failed to write message: %s", err)
+	}
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("failed to read echoed message: %s", err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > baseline {
+		t.Fatalf("expected the splice goroutines to exit after the client disconnected, baseline=%d, now=%d", baseline, got)
+	}
+}
+
+func TestWebSocketIdleTimeoutClosesTheClientConnection(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	defer upstream.Close()
+	proxy := newProxyServer(t, upstream, 100*time.Millisecond)
+	defer proxy.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	if err != nil {
+		t.Fatalf("failed to dial the proxy: %s", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if err == nil {
+		t.Fatal("expected the idle connection to be closed by the proxy")
+	}
+}
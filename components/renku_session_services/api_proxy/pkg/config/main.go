@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"reflect"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
@@ -19,6 +20,26 @@ type Config struct {
 	RenkuClientID     string         `mapstructure:"renku_client_id"`
 	RenkuClientSecret RedactedString `mapstructure:"renku_client_secret"`
 	RenkuURL          *url.URL       `mapstructure:"renku_url"`
+	MetricsHost       string         `mapstructure:"metrics_host"`
+	MetricsPort       int            `mapstructure:"metrics_port"`
+	// RefreshLeeway is how far ahead of the access token's `exp` claim a refresh is triggered.
+	RefreshLeeway time.Duration `mapstructure:"refresh_leeway"`
+	// JWKSVerify enables signature verification of incoming bearer tokens against the realm's JWKS.
+	JWKSVerify bool `mapstructure:"jwks_verify"`
+	// JWKSRefreshInterval is how often the cached JWKS is refreshed in the background. A
+	// Cache-Control max-age on the JWKS response takes precedence over it.
+	JWKSRefreshInterval time.Duration `mapstructure:"jwks_refresh_interval"`
+	// ExpectedAudience is the `aud` claim required on incoming bearer tokens when JWKSVerify is enabled.
+	ExpectedAudience string `mapstructure:"expected_audience"`
+	// ProxyIdleTimeout closes an upgraded (e.g. WebSocket) connection once either direction has carried no
+	// data for this long. Plain HTTP requests proxied without an upgrade are not subject to it.
+	ProxyIdleTimeout time.Duration `mapstructure:"proxy_idle_timeout"`
+	// RoutesConfigPath points at a YAML file describing the proxy's routing table. If empty, the
+	// proxy falls back to its legacy single api/data route.
+	RoutesConfigPath string `mapstructure:"routes_config_path"`
+	// AdminSharedSecret gates the /admin/reload and /admin/routes endpoints. They are disabled
+	// entirely (always 401) if this is left empty.
+	AdminSharedSecret RedactedString `mapstructure:"admin_shared_secret"`
 }
 
 func LoadAndValidateConfig() (config Config, err error) {
@@ -48,8 +69,20 @@ func loadConfig() (config Config, err error) {
 	v.SetDefault("renku_client_id", "")
 	v.SetDefault("renku_client_secret", "")
 	v.SetDefault("renku_url", nil)
+	v.SetDefault("metrics_host", "")
+	v.SetDefault("metrics_port", 58081)
+	v.SetDefault("refresh_leeway", "30s")
+	v.SetDefault("jwks_verify", false)
+	v.SetDefault("jwks_refresh_interval", "5m")
+	v.SetDefault("expected_audience", "")
+	v.SetDefault("proxy_idle_timeout", "5m")
+	v.SetDefault("routes_config_path", "")
+	v.SetDefault("admin_shared_secret", "")
 
-	dh := viper.DecodeHook(parseStringAsURL())
+	dh := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		parseStringAsURL(),
+	))
 	err = v.Unmarshal(&config, dh)
 	if err != nil {
 		return Config{}, err
@@ -79,6 +112,9 @@ func (c *Config) Validate() error {
 	if c.RenkuClientSecret == "" {
 		return fmt.Errorf("the renku client secret is not defined")
 	}
+	if c.JWKSVerify && c.ExpectedAudience == "" {
+		return fmt.Errorf("jwks_verify is enabled but expected_audience is not defined")
+	}
 	return nil
 }
 
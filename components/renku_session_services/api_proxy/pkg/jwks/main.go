@@ -0,0 +1,216 @@
+// Package jwks fetches and caches a Keycloak realm's JSON Web Key Set so that
+// bearer tokens presented to the proxy can have their signature verified
+// instead of being trusted and forwarded unchecked.
+package jwks
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultTTL is the starting TTL when New is given a non-positive refresh interval.
+const defaultTTL = 5 * time.Minute
+
+// KeySet fetches and caches the RSA public keys published at a JWKS endpoint,
+// keyed by `kid`, and verifies JWTs signed with one of them.
+type KeySet struct {
+	url      string
+	issuer   string
+	audience string
+	client   *http.Client
+
+	mu   sync.RWMutex
+	keys map[string]*rsa.PublicKey
+	ttl  time.Duration
+}
+
+// New creates a KeySet that fetches keys from jwksURL and validates that
+// verified tokens carry the given issuer and audience. refreshInterval is the
+// initial background-refresh period (defaultTTL if it is not positive). A
+// Cache-Control max-age on a JWKS response replaces the period; a later
+// response without one keeps the period currently in effect.
+func New(jwksURL, issuer, audience string, refreshInterval time.Duration) *KeySet {
+	ttl := refreshInterval
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return &KeySet{
+		url:      jwksURL,
+		issuer:   issuer,
+		audience: audience,
+		ttl:      ttl,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		keys:     map[string]*rsa.PublicKey{},
+	}
+}
+
+// Verify checks the signature, issuer, audience and expiry of tokenString. If
+// the token's `kid` is not in the cache, it triggers an on-demand refresh
+// before giving up.
+func (k *KeySet) Verify(tokenString string) (*jwt.Token, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithIssuer(k.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+	}
+	// An empty audience means "don't check the aud claim": jwt.WithAudience("")
+	// would otherwise require the claim to literally equal "", rejecting every
+	// real token.
+	if k.audience != "" {
+		opts = append(opts, jwt.WithAudience(k.audience))
+	}
+	token, err := jwt.Parse(tokenString, k.keyFunc, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("jwt verification failed: %w", err)
+	}
+	return token, nil
+}
+
+func (k *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid == "" {
+		return nil, fmt.Errorf("token has no kid header")
+	}
+	if key, ok := k.getKey(kid); ok {
+		return key, nil
+	}
+	// Unknown kid: the realm may have rotated its keys, refresh on demand.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := k.Refresh(ctx); err != nil {
+		return nil, fmt.Errorf("could not refresh jwks after unknown kid %q: %w", kid, err)
+	}
+	key, ok := k.getKey(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown kid: %s", kid)
+	}
+	return key, nil
+}
+
+func (k *KeySet) getKey(kid string) (*rsa.PublicKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// Refresh fetches the JWKS document and replaces the cached key set.
+func (k *KeySet) Refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching jwks failed with status code: %d", res.StatusCode)
+	}
+
+	var doc jwksDocument
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		if jwk.Kty != "RSA" || jwk.Kid == "" {
+			continue
+		}
+		key, err := jwk.rsaPublicKey()
+		if err != nil {
+			slog.Info("skipping jwks entry with invalid key material", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.ttl = cacheTTL(res.Header.Get("Cache-Control"), k.ttl)
+	k.mu.Unlock()
+
+	return nil
+}
+
+func (k *KeySet) getTTL() time.Duration {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.ttl
+}
+
+// StartBackgroundRefresh refreshes the key set on an interval until ctx is
+// cancelled. Refresh errors are logged and the previous cache is kept.
+func (k *KeySet) StartBackgroundRefresh(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(k.getTTL()):
+			if err := k.Refresh(ctx); err != nil {
+				slog.Info("background jwks refresh failed, keeping previous key set", "error", err)
+			}
+		}
+	}
+}
+
+type jwksDocument struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (j jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(j.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(j.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	e := 0
+	for _, b := range eBytes {
+		e = e<<8 | int(b)
+	}
+	if e == 0 {
+		return nil, fmt.Errorf("exponent is zero")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nBytes), E: e}, nil
+}
+
+// cacheTTL parses a Cache-Control header for a max-age directive, falling back
+// to the given default when absent or unparsable.
+func cacheTTL(cacheControl string, fallback time.Duration) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err != nil || seconds <= 0 {
+			continue
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return fallback
+}
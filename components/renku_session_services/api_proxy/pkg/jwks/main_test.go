@@ -0,0 +1,173 @@
+package jwks
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://renku.example.com/auth/realms/test"
+	testAudience = "test-audience"
+	testKid      = "test-key-1"
+)
+
+func newTestKeyPair(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %s", err)
+	}
+	return key
+}
+
+func jwkFor(kid string, pub *rsa.PublicKey) jsonWebKey {
+	return jsonWebKey{
+		Kty: "RSA",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+// newFakeJWKSServer serves the given keys as a JWKS document. The keys are
+// read through the passed-in *[]jsonWebKey on every request, so reassigning
+// the slice mid-test simulates key rotation.
+func newFakeJWKSServer(t *testing.T, keys *[]jsonWebKey) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(jwksDocument{Keys: *keys})
+	}))
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign test token: %s", err)
+	}
+	return signed
+}
+
+func validClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Issuer:    testIssuer,
+		Audience:  jwt.ClaimStrings{testAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+func TestVerifyAcceptsValidToken(t *testing.T) {
+	key := newTestKeyPair(t)
+	keys := []jsonWebKey{jwkFor(testKid, &key.PublicKey)}
+	server := newFakeJWKSServer(t, &keys)
+	defer server.Close()
+
+	ks := New(server.URL, testIssuer, testAudience, time.Minute)
+	if err := ks.Refresh(contextWithTimeout(t)); err != nil {
+		t.Fatalf("initial refresh failed: %s", err)
+	}
+
+	tokenString := signToken(t, key, testKid, validClaims())
+	if _, err := ks.Verify(tokenString); err != nil {
+		t.Fatalf("expected token to verify, got error: %s", err)
+	}
+}
+
+func TestVerifyRejectsExpiredToken(t *testing.T) {
+	key := newTestKeyPair(t)
+	keys := []jsonWebKey{jwkFor(testKid, &key.PublicKey)}
+	server := newFakeJWKSServer(t, &keys)
+	defer server.Close()
+
+	ks := New(server.URL, testIssuer, testAudience, time.Minute)
+	if err := ks.Refresh(contextWithTimeout(t)); err != nil {
+		t.Fatalf("initial refresh failed: %s", err)
+	}
+
+	claims := validClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	tokenString := signToken(t, key, testKid, claims)
+	if _, err := ks.Verify(tokenString); err == nil {
+		t.Fatal("expected an expired token to be rejected")
+	}
+}
+
+func TestVerifyRejectsWrongAudience(t *testing.T) {
+	key := newTestKeyPair(t)
+	keys := []jsonWebKey{jwkFor(testKid, &key.PublicKey)}
+	server := newFakeJWKSServer(t, &keys)
+	defer server.Close()
+
+	ks := New(server.URL, testIssuer, testAudience, time.Minute)
+	if err := ks.Refresh(contextWithTimeout(t)); err != nil {
+		t.Fatalf("initial refresh failed: %s", err)
+	}
+
+	claims := validClaims()
+	claims.Audience = jwt.ClaimStrings{"someone-else"}
+	tokenString := signToken(t, key, testKid, claims)
+	if _, err := ks.Verify(tokenString); err == nil {
+		t.Fatal("expected a token with the wrong audience to be rejected")
+	}
+}
+
+func TestVerifyWithEmptyAudienceAcceptsAnyAudience(t *testing.T) {
+	key := newTestKeyPair(t)
+	keys := []jsonWebKey{jwkFor(testKid, &key.PublicKey)}
+	server := newFakeJWKSServer(t, &keys)
+	defer server.Close()
+
+	ks := New(server.URL, testIssuer, "", time.Minute)
+	if err := ks.Refresh(contextWithTimeout(t)); err != nil {
+		t.Fatalf("initial refresh failed: %s", err)
+	}
+
+	claims := validClaims()
+	claims.Audience = jwt.ClaimStrings{"whatever-keycloak-put-there"}
+	tokenString := signToken(t, key, testKid, claims)
+	if _, err := ks.Verify(tokenString); err != nil {
+		t.Fatalf("expected an empty configured audience to skip the aud check, got: %s", err)
+	}
+}
+
+func TestVerifyRefreshesOnUnknownKid(t *testing.T) {
+	oldKey := newTestKeyPair(t)
+	keys := []jsonWebKey{jwkFor(testKid, &oldKey.PublicKey)}
+	server := newFakeJWKSServer(t, &keys)
+	defer server.Close()
+
+	ks := New(server.URL, testIssuer, testAudience, time.Minute)
+	if err := ks.Refresh(contextWithTimeout(t)); err != nil {
+		t.Fatalf("initial refresh failed: %s", err)
+	}
+
+	// Rotate to a brand new key/kid, as Keycloak would after a realm key rotation.
+	newKey := newTestKeyPair(t)
+	const newKid = "test-key-2"
+	keys = []jsonWebKey{jwkFor(newKid, &newKey.PublicKey)}
+
+	tokenString := signToken(t, newKey, newKid, validClaims())
+	if _, err := ks.Verify(tokenString); err != nil {
+		t.Fatalf("expected the unknown kid to trigger a refresh and succeed, got: %s", err)
+	}
+}
+
+func contextWithTimeout(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
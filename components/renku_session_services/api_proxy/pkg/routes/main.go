@@ -0,0 +1,130 @@
+// Package routes loads the proxy's routing table from a YAML file and watches
+// it for changes, so a single proxy instance can front several upstreams
+// (data, core, KG, notebooks, ...) with per-route token-injection policies
+// instead of requiring one sidecar per upstream.
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// RouteRule describes one path prefix the proxy forwards to an upstream.
+type RouteRule struct {
+	PathPrefix  string
+	Upstream    *url.URL
+	InjectToken bool
+	StripPrefix bool
+}
+
+type fileRouteRule struct {
+	PathPrefix  string `yaml:"path_prefix"`
+	Upstream    string `yaml:"upstream"`
+	InjectToken bool   `yaml:"inject_token"`
+	StripPrefix bool   `yaml:"strip_prefix"`
+}
+
+type routeFile struct {
+	Routes []fileRouteRule `yaml:"routes"`
+}
+
+// Load reads and parses a routing table from a YAML file shaped like:
+//
+//	routes:
+//	  - path_prefix: /api/data
+//	    upstream: http://data-service.renku.svc.cluster.local
+//	    inject_token: true
+//	  - path_prefix: /api/core
+//	    upstream: http://core-service.renku.svc.cluster.local
+//	    inject_token: true
+//	    strip_prefix: true
+func Load(path string) ([]RouteRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading route configuration %q: %w", path, err)
+	}
+	var doc routeFile
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing route configuration %q: %w", path, err)
+	}
+	rules := make([]RouteRule, 0, len(doc.Routes))
+	for _, r := range doc.Routes {
+		if r.PathPrefix == "" {
+			return nil, fmt.Errorf("route configuration %q has a rule with an empty path_prefix", path)
+		}
+		upstream, err := url.Parse(r.Upstream)
+		if err != nil {
+			return nil, fmt.Errorf("route %q has an invalid upstream %q: %w", r.PathPrefix, r.Upstream, err)
+		}
+		rules = append(rules, RouteRule{
+			PathPrefix:  r.PathPrefix,
+			Upstream:    upstream,
+			InjectToken: r.InjectToken,
+			StripPrefix: r.StripPrefix,
+		})
+	}
+	return rules, nil
+}
+
+// Watcher reloads a routing table whenever its backing file changes on disk.
+type Watcher struct {
+	path    string
+	watcher *fsnotify.Watcher
+}
+
+// NewWatcher watches the directory containing path (rather than path itself)
+// so that editors and config-management tools that replace the file via a
+// rename/remove-and-recreate are still noticed.
+func NewWatcher(path string) (*Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("creating the route configuration watcher: %w", err)
+	}
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("watching the route configuration directory: %w", err)
+	}
+	return &Watcher{path: path, watcher: w}, nil
+}
+
+// Start runs the watch loop until ctx is cancelled, calling onChange with the
+// freshly loaded routing table every time path is written to. A reload that
+// fails to parse is logged and skipped, leaving the previous table in place.
+func (w *Watcher) Start(ctx context.Context, onChange func([]RouteRule)) {
+	defer w.watcher.Close()
+	target := filepath.Clean(w.path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != target {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			rules, err := Load(w.path)
+			if err != nil {
+				slog.Info("ignoring invalid route configuration reload", "error", err)
+				continue
+			}
+			onChange(rules)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Info("route configuration watcher error", "error", err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRouteFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write test route file: %s", err)
+	}
+	return path
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("failed to parse test URL %q: %s", raw, err)
+	}
+	return u
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name      string
+		contents  string
+		wantErr   bool
+		wantRules []RouteRule
+	}{
+		{
+			name: "multiple routes with defaults and overrides",
+			contents: `
+routes:
+  - path_prefix: /api/data
+    upstream: http://data-service.renku.svc.cluster.local
+    inject_token: true
+  - path_prefix: /api/core
+    upstream: http://core-service.renku.svc.cluster.local
+    inject_token: true
+    strip_prefix: true
+`,
+			wantRules: []RouteRule{
+				{PathPrefix: "/api/data", Upstream: mustParseURL(t, "http://data-service.renku.svc.cluster.local"), InjectToken: true, StripPrefix: false},
+				{PathPrefix: "/api/core", Upstream: mustParseURL(t, "http://core-service.renku.svc.cluster.local"), InjectToken: true, StripPrefix: true},
+			},
+		},
+		{
+			name:      "empty file yields no routes",
+			contents:  ``,
+			wantRules: []RouteRule{},
+		},
+		{
+			name: "missing path_prefix is rejected",
+			contents: `
+routes:
+  - upstream: http://data-service.renku.svc.cluster.local
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid upstream is rejected",
+			contents: `
+routes:
+  - path_prefix: /api/data
+    upstream: "://not-a-url"
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeRouteFile(t, tt.contents)
+			rules, err := Load(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(rules) != len(tt.wantRules) {
+				t.Fatalf("expected %d rules, got %d: %+v", len(tt.wantRules), len(rules), rules)
+			}
+			for i, want := range tt.wantRules {
+				got := rules[i]
+				if got.PathPrefix != want.PathPrefix || got.InjectToken != want.InjectToken || got.StripPrefix != want.StripPrefix || got.Upstream.String() != want.Upstream.String() {
+					t.Fatalf("rule %d: expected %+v, got %+v", i, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "does-not-exist.yaml")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -2,7 +2,9 @@ package tokenstore
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
@@ -11,13 +13,44 @@ import (
 
 	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/config"
 	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
 )
 
+const (
+	// refreshBackoffBase is the base delay of the full-jitter backoff used between
+	// retried refresh attempts.
+	refreshBackoffBase = 200 * time.Millisecond
+	// refreshBackoffCap caps the backoff delay so retries don't wait indefinitely.
+	refreshBackoffCap = 5 * time.Second
+	// refreshMaxAttempts is the total number of attempts (the first try plus retries).
+	refreshMaxAttempts = 4
+)
+
+// ErrTerminalRefresh indicates that Keycloak rejected the refresh token outright
+// (bad request or unauthorized), so retrying the same refresh token is pointless.
+type ErrTerminalRefresh struct {
+	StatusCode int
+}
+
+func (e *ErrTerminalRefresh) Error() string {
+	return fmt.Sprintf("renku access token refresh was rejected, status code: %d", e.StatusCode)
+}
+
+// errRetryable wraps a transient failure (network error or 5xx) that is worth
+// retrying with backoff.
+type errRetryable struct {
+	err error
+}
+
+func (e *errRetryable) Error() string { return e.err.Error() }
+func (e *errRetryable) Unwrap() error { return e.err }
+
 type TokenStore struct {
 	Config               *config.Config
 	renkuAccessToken     string
 	renkuRefreshToken    string
 	renkuAccessTokenLock *sync.RWMutex
+	refreshGroup         singleflight.Group
 }
 
 func New(c *config.Config) *TokenStore {
@@ -49,18 +82,84 @@ func (s *TokenStore) getRenkuAccessToken() string {
 	return s.renkuAccessToken
 }
 
+func (s *TokenStore) getRenkuRefreshToken() string {
+	s.renkuAccessTokenLock.RLock()
+	defer s.renkuAccessTokenLock.RUnlock()
+	return s.renkuRefreshToken
+}
+
+func (s *TokenStore) invalidateRefreshToken() {
+	s.renkuAccessTokenLock.Lock()
+	defer s.renkuAccessTokenLock.Unlock()
+	s.renkuRefreshToken = ""
+}
+
 type renkuTokenRefreshResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 }
 
-// Refreshes the renku access token.
+// refreshRenkuAccessToken refreshes the renku access token. Concurrent callers
+// racing in for the same refresh token are coalesced into a single upstream
+// call via singleflight, and the result (or error) is broadcast to all of them.
 func (s *TokenStore) refreshRenkuAccessToken() error {
-	s.renkuAccessTokenLock.Lock()
-	defer s.renkuAccessTokenLock.Unlock()
+	key := s.getRenkuRefreshToken()
+	_, err, _ := s.refreshGroup.Do(key, func() (interface{}, error) {
+		return nil, s.refreshRenkuAccessTokenWithRetry()
+	})
+	return err
+}
+
+// refreshRenkuAccessTokenWithRetry retries transient failures (network errors,
+// 5xx responses) with exponential backoff and full jitter, up to
+// refreshMaxAttempts. A terminal rejection from Keycloak (400/401) is not
+// retried: the refresh token is invalidated and the error returned immediately.
+func (s *TokenStore) refreshRenkuAccessTokenWithRetry() error {
+	var lastErr error
+	for attempt := 1; attempt <= refreshMaxAttempts; attempt++ {
+		err := s.doRefreshRenkuAccessToken()
+		if err == nil {
+			return nil
+		}
+
+		var terminal *ErrTerminalRefresh
+		if errors.As(err, &terminal) {
+			s.invalidateRefreshToken()
+			return err
+		}
+
+		var retryable *errRetryable
+		if !errors.As(err, &retryable) {
+			return err
+		}
+		lastErr = err
+		if attempt < refreshMaxAttempts {
+			time.Sleep(fullJitterBackoff(attempt))
+		}
+	}
+	return lastErr
+}
+
+// fullJitterBackoff returns a random delay in [0, min(cap, base*2^(attempt-1)))
+// per the "full jitter" strategy, for the given 1-indexed attempt number.
+func fullJitterBackoff(attempt int) time.Duration {
+	backoff := refreshBackoffBase * time.Duration(uint64(1)<<uint(attempt-1))
+	if backoff > refreshBackoffCap || backoff <= 0 {
+		backoff = refreshBackoffCap
+	}
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// doRefreshRenkuAccessToken performs a single refresh-token call against
+// Keycloak. A nil return updates the stored access (and, if rotated, refresh)
+// token. Network errors and 5xx responses are wrapped in errRetryable; 400/401
+// are reported as ErrTerminalRefresh; anything else is a plain, non-retried error.
+func (s *TokenStore) doRefreshRenkuAccessToken() error {
+	refreshToken := s.getRenkuRefreshToken()
+
 	payload := url.Values{}
 	payload.Add("grant_type", "refresh_token")
-	payload.Add("refresh_token", s.renkuRefreshToken)
+	payload.Add("refresh_token", refreshToken)
 	body := strings.NewReader(payload.Encode())
 	req, err := http.NewRequest(http.MethodPost, s.Config.RenkuURL.JoinPath(fmt.Sprintf("auth/realms/%s/protocol/openid-connect/token", s.Config.RenkuRealm)).String(), body)
 	if err != nil {
@@ -70,17 +169,26 @@ func (s *TokenStore) refreshRenkuAccessToken() error {
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return &errRetryable{err}
 	}
-	if res.StatusCode != 200 {
-		err = fmt.Errorf("cannot refresh renku access token, failed with staus code: %d", res.StatusCode)
-		return err
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusBadRequest || res.StatusCode == http.StatusUnauthorized:
+		return &ErrTerminalRefresh{StatusCode: res.StatusCode}
+	case res.StatusCode >= 500:
+		return &errRetryable{fmt.Errorf("cannot refresh renku access token, failed with status code: %d", res.StatusCode)}
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("cannot refresh renku access token, failed with status code: %d", res.StatusCode)
 	}
+
 	var resParsed renkuTokenRefreshResponse
-	err = json.NewDecoder(res.Body).Decode(&resParsed)
-	if err != nil {
+	if err = json.NewDecoder(res.Body).Decode(&resParsed); err != nil {
 		return err
 	}
+
+	s.renkuAccessTokenLock.Lock()
+	defer s.renkuAccessTokenLock.Unlock()
 	s.renkuAccessToken = resParsed.AccessToken
 	if resParsed.RefreshToken != "" {
 		s.renkuRefreshToken = resParsed.RefreshToken
@@ -88,14 +196,14 @@ func (s *TokenStore) refreshRenkuAccessToken() error {
 	return nil
 }
 
-// Checks if the expiry of the token has passed or is coming up soon based on a predefined threshold.
+// Checks if the expiry of the token has passed, or is coming up within
+// Config.RefreshLeeway, based on the `exp` claim.
 // NOTE: no signature validation is performed at all. All of the tokens in the proxy are trusted implicitly
 // because they come from trusted/controlled sources.
 func (s *TokenStore) isJWTExpired(token string) (isExpired bool, err error) {
 	parser := jwt.NewParser()
 	claims := jwt.RegisteredClaims{}
 	if _, _, err := parser.ParseUnverified(token, &claims); err != nil {
-		// log.Printf("Cannot parse token claims, assuming token is expired: %s\n", err.Error())
 		return true, err
 	}
 	expiresAt, err := claims.GetExpirationTime()
@@ -106,13 +214,7 @@ func (s *TokenStore) isJWTExpired(token string) (isExpired bool, err error) {
 	if expiresAt == nil {
 		return false, nil
 	}
-	now := time.Now()
+	now := time.Now().Add(s.Config.RefreshLeeway)
 	valid := now.Before(expiresAt.Time)
 	return !valid, nil
-
-	// // VerifyExpiresAt returns cmp.Before(exp) if exp is set, otherwise !req if exp is not set.
-	// // Here we have it setup so that if the exp claim is not defined we assume the token is not expired.
-	// // Keycloak does not set the `exp` claim on tokens that have the offline access grant - because they do not expire.
-	// jwtIsNotExpired := claims.VerifyExpiresAt(time.Now().Add(s.ExpirationLeeway), false)
-	// return !jwtIsNotExpired, nil
 }
@@ -0,0 +1,175 @@
+package tokenstore
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-data-services/components/renku_session_services/api_proxy/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// expiredAccessToken returns a JWT, signed with a throwaway HMAC key, whose exp claim is in the past.
+func expiredAccessToken(t *testing.T) string {
+	t.Helper()
+	claims := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour))}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("unused"))
+	if err != nil {
+		t.Fatalf("failed to build test token: %s", err)
+	}
+	return token
+}
+
+// validAccessToken returns a JWT, signed with a throwaway HMAC key, whose exp claim is in the future,
+// so a benign re-read of an already-refreshed token doesn't look like a parse error.
+func validAccessToken(t *testing.T) string {
+	t.Helper()
+	claims := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("unused"))
+	if err != nil {
+		t.Fatalf("failed to build test token: %s", err)
+	}
+	return token
+}
+
+func newTestConfig(t *testing.T, serverURL string) *config.Config {
+	t.Helper()
+	parsed, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatalf("failed to parse test server URL: %s", err)
+	}
+	return &config.Config{
+		RenkuURL:          parsed,
+		RenkuRealm:        "test-realm",
+		RenkuClientID:     "test-client",
+		RenkuClientSecret: "test-secret",
+		RenkuAccessToken:  config.RedactedString(expiredAccessToken(t)),
+		RenkuRefreshToken: "initial-refresh-token",
+	}
+}
+
+func TestRefreshTerminalBadRequestDoesNotRetry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	cfg := newTestConfig(t, server.URL)
+	store := New(cfg)
+
+	_, err := store.GetValidRenkuAccessToken()
+	if err == nil {
+		t.Fatal("expected an error refreshing the token")
+	}
+	var terminal *ErrTerminalRefresh
+	if !errors.As(err, &terminal) {
+		t.Fatalf("expected an ErrTerminalRefresh, got: %T %v", err, err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected exactly 1 call to keycloak, got %d", got)
+	}
+	if store.getRenkuRefreshToken() != "" {
+		t.Fatal("expected the refresh token to be invalidated after a terminal rejection")
+	}
+}
+
+func TestRefreshRetriesTransientServerErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(renkuTokenRefreshResponse{AccessToken: "new-access-token"})
+	}))
+	defer server.Close()
+
+	cfg := newTestConfig(t, server.URL)
+	store := New(cfg)
+
+	token, err := store.GetValidRenkuAccessToken()
+	if err != nil {
+		t.Fatalf("expected the refresh to eventually succeed, got error: %s", err)
+	}
+	if token != "new-access-token" {
+		t.Fatalf("expected the new access token to be returned, got: %s", token)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("expected exactly 3 calls to keycloak (2 failures + 1 success), got %d", got)
+	}
+}
+
+func TestRefreshGivesUpAfterMaxAttempts(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := newTestConfig(t, server.URL)
+	store := New(cfg)
+
+	_, err := store.GetValidRenkuAccessToken()
+	if err == nil {
+		t.Fatal("expected an error after exhausting all retries")
+	}
+	if got := atomic.LoadInt32(&calls); got != refreshMaxAttempts {
+		t.Fatalf("expected %d calls to keycloak, got %d", refreshMaxAttempts, got)
+	}
+}
+
+func TestConcurrentRefreshesAreCoalesced(t *testing.T) {
+	sharedAccessToken := validAccessToken(t)
+	var calls int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(renkuTokenRefreshResponse{AccessToken: sharedAccessToken})
+	}))
+	defer server.Close()
+
+	cfg := newTestConfig(t, server.URL)
+	store := New(cfg)
+
+	const numCallers = 10
+	var wg sync.WaitGroup
+	results := make([]string, numCallers)
+	errs := make([]error, numCallers)
+	for i := 0; i < numCallers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = store.GetValidRenkuAccessToken()
+		}(i)
+	}
+
+	// Give every goroutine a chance to reach the in-flight refresh before releasing it.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected exactly 1 upstream call for %d concurrent refreshes, got %d", numCallers, got)
+	}
+	for i := 0; i < numCallers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("caller %d got an unexpected error: %s", i, errs[i])
+		}
+		if results[i] != sharedAccessToken {
+			t.Fatalf("caller %d got an unexpected token: %s", i, results[i])
+		}
+	}
+}